@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) SAS Institute Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"gerrit-pdt.unx.sas.com/tools/relic.git/cmd/shared"
+	"gerrit-pdt.unx.sas.com/tools/relic.git/signers/msi"
+)
+
+var msiTimestampsCmd = &cobra.Command{
+	Use:   "msi-timestamps file",
+	Short: "List the RFC3161 timestamps attached to a signed MSI",
+	Args:  cobra.ExactArgs(1),
+	RunE:  msiTimestampsRun,
+}
+
+func init() {
+	shared.RootCmd.AddCommand(msiTimestampsCmd)
+}
+
+func msiTimestampsRun(cmd *cobra.Command, args []string) error {
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	times, err := msi.VerifyTimestamps(f)
+	if err != nil {
+		return fmt.Errorf("msi: %w", err)
+	}
+	if len(times) == 0 {
+		fmt.Println("no timestamps attached")
+		return nil
+	}
+	for _, t := range times {
+		fmt.Println(t.Format("2006-01-02T15:04:05Z07:00"))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) SAS Institute Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/cobra"
+
+	"gerrit-pdt.unx.sas.com/tools/relic.git/cmd/shared"
+	"gerrit-pdt.unx.sas.com/tools/relic.git/signers/msi"
+)
+
+var signifyKeygenCmd = &cobra.Command{
+	Use:   "signify-keygen",
+	Short: "Generate a signify Ed25519 key pair for MSI side-signatures",
+	RunE:  signifyKeygenRun,
+}
+
+var argSignifyKeygenOut string
+
+func init() {
+	shared.RootCmd.AddCommand(signifyKeygenCmd)
+	signifyKeygenCmd.Flags().StringVar(&argSignifyKeygenOut, "out", "signify", "Base path to write <out>.sec and <out>.pub to")
+}
+
+func signifyKeygenRun(cmd *cobra.Command, args []string) error {
+	secretKey, publicKey, err := msi.GenerateSignifyKeygen()
+	if err != nil {
+		return fmt.Errorf("generating signify key pair: %w", err)
+	}
+	if err := ioutil.WriteFile(argSignifyKeygenOut+".sec", secretKey, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(argSignifyKeygenOut+".pub", publicKey, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s.sec and %s.pub\n", argSignifyKeygenOut, argSignifyKeygenOut)
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) SAS Institute Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package authenticode
+
+// Side streams that ride alongside the MSI's primary \5DigitalSignature
+// without disturbing Authenticode verifiers that don't know about them:
+// reading back the signature InsertMSISignature already wrote, and
+// retimestamp's need to read it back without re-signing.
+
+import (
+	"os"
+
+	"gerrit-pdt.unx.sas.com/tools/relic.git/lib/comdoc"
+)
+
+const msiSignatureStream = "\x05DigitalSignature"
+const msiExSigStream = "\x05MsiDigitalSignatureEx"
+const msiAttestationStream = "\x05DigitalAttestation"
+const msiSignifyStream = "\x05SignifySignature"
+
+// ExtractMSISignature reads back the PKCS#7 blob and, if present, the
+// MsiDigitalSignatureEx digest that InsertMSISignature wrote, so a signature
+// can be inspected or replaced without redoing the whole signing process.
+func ExtractMSISignature(cdf *comdoc.ComDoc) (psd, exsig []byte, err error) {
+	psd, err = cdf.ReadStream(msiSignatureStream)
+	if err != nil {
+		return nil, nil, err
+	}
+	exsig, err = cdf.ReadStream(msiExSigStream)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return psd, nil, nil
+		}
+		return nil, nil, err
+	}
+	return psd, exsig, nil
+}
+
+// InsertMSIAttestation writes a DSSE-wrapped in-toto attestation into its
+// own stream, alongside the Authenticode signature rather than inside it, so
+// existing Authenticode verifiers that don't know about attestations simply
+// ignore it.
+func InsertMSIAttestation(cdf *comdoc.ComDoc, blob []byte) error {
+	return cdf.WriteStream(msiAttestationStream, blob)
+}
+
+// ExtractMSIAttestation reads back a stream written by InsertMSIAttestation.
+// It returns a nil blob, not an error, if the MSI has no attestation.
+func ExtractMSIAttestation(cdf *comdoc.ComDoc) ([]byte, error) {
+	blob, err := cdf.ReadStream(msiAttestationStream)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return blob, nil
+}
+
+// InsertMSISignify writes a signify-format Ed25519 side-signature into its
+// own stream, alongside the Authenticode signature rather than inside it.
+func InsertMSISignify(cdf *comdoc.ComDoc, blob []byte) error {
+	return cdf.WriteStream(msiSignifyStream, blob)
+}
+
+// ExtractMSISignify reads back a stream written by InsertMSISignify. It
+// returns a nil blob, not an error, if the MSI has no signify signature.
+func ExtractMSISignify(cdf *comdoc.ComDoc) ([]byte, error) {
+	blob, err := cdf.ReadStream(msiSignifyStream)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return blob, nil
+}
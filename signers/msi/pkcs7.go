@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) SAS Institute Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package msi
+
+// Just enough PKCS#7 SignedData parsing to inspect the signed message
+// digest of an Authenticode blob. This deliberately doesn't try to be a
+// general CMS implementation -- it only needs to round-trip the shapes
+// that authenticode.SignMSIImprint itself produces.
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"time"
+)
+
+var oidRFC3161Timestamp = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 3, 3, 1}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional,explicit,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber asn1.RawValue
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   []pkcs7Attribute `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes []pkcs7Attribute `asn1:"optional,tag:1"`
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// parseSignedData unwraps the outer ContentInfo that authenticode's PKCS#7
+// blob is wrapped in and decodes the inner SignedData.
+func parseSignedData(der []byte) (*pkcs7SignedData, error) {
+	var outer pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &outer); err != nil {
+		return nil, err
+	}
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(outer.Content.Bytes, &sd); err != nil {
+		return nil, err
+	}
+	return &sd, nil
+}
+
+// spcIndirectDataContent is Authenticode's SpcIndirectDataContent, the
+// eContent of the PKCS#7's ContentInfo. It's what actually carries the MSI
+// imprint -- the messageDigest authenticated attribute is a digest of this
+// structure, not the imprint itself.
+type spcIndirectDataContent struct {
+	Data          asn1.RawValue
+	MessageDigest digestInfo
+}
+
+type digestInfo struct {
+	DigestAlgorithm pkix.AlgorithmIdentifier
+	Digest          []byte
+}
+
+// imprint returns the MSI imprint that was actually signed, i.e. the digest
+// field of the SpcIndirectDataContent wrapped by the PKCS#7's ContentInfo.
+func (sd *pkcs7SignedData) imprint() ([]byte, error) {
+	var octets []byte
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &octets); err != nil {
+		return nil, err
+	}
+	var indirect spcIndirectDataContent
+	if _, err := asn1.Unmarshal(octets, &indirect); err != nil {
+		return nil, err
+	}
+	return indirect.MessageDigest.Digest, nil
+}
+
+// signingCertificate returns the leaf (first) certificate embedded in the
+// SignedData's optional certificates set, i.e. the one that produced
+// SignerInfos[0].EncryptedDigest.
+func (sd *pkcs7SignedData) signingCertificate() (*x509.Certificate, error) {
+	if len(sd.Certificates.Bytes) == 0 {
+		return nil, errors.New("msi: PKCS#7 has no embedded certificates")
+	}
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(sd.Certificates.Bytes, &raw); err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(raw.FullBytes)
+}
+
+// tstInfo is the subset of RFC 3161's TSTInfo that we care about: when the
+// timestamp was issued.
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint asn1.RawValue
+	SerialNumber   asn1.RawValue
+	GenTime        time.Time `asn1:"generalized"`
+}
+
+// timestamps returns the signing time of every RFC3161 timestamp token
+// attached to the first signer as a szOID_RFC3161_counterSign unauthenticated
+// attribute, in the order they appear.
+func (sd *pkcs7SignedData) timestamps() ([]time.Time, error) {
+	if len(sd.SignerInfos) == 0 {
+		return nil, nil
+	}
+	var times []time.Time
+	for _, attr := range sd.SignerInfos[0].UnauthenticatedAttributes {
+		if !attr.Type.Equal(oidRFC3161Timestamp) {
+			continue
+		}
+		for _, value := range attr.Values {
+			var outer pkcs7ContentInfo
+			if _, err := asn1.Unmarshal(value.FullBytes, &outer); err != nil {
+				return nil, err
+			}
+			var token pkcs7SignedData
+			if _, err := asn1.Unmarshal(outer.Content.Bytes, &token); err != nil {
+				return nil, err
+			}
+			// Like SpcIndirectDataContent in imprint(), TSTInfo is wrapped
+			// in an OCTET STRING eContent (RFC 5652 5.2) rather than being
+			// the direct content of the ContentInfo.
+			var octets []byte
+			if _, err := asn1.Unmarshal(token.ContentInfo.Content.Bytes, &octets); err != nil {
+				return nil, err
+			}
+			var info tstInfo
+			if _, err := asn1.Unmarshal(octets, &info); err != nil {
+				return nil, err
+			}
+			times = append(times, info.GenTime)
+		}
+	}
+	return times, nil
+}
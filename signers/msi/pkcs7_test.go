@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) SAS Institute Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package msi
+
+import (
+	"encoding/asn1"
+	"testing"
+	"time"
+)
+
+var (
+	oidTestSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTestTSTInfo    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+)
+
+// explicitWrap manually builds the DER header for a context-specific,
+// constructed, EXPLICIT tag around an already-encoded TLV. asn1.RawValue
+// fields are emitted verbatim by encoding/asn1's Marshal -- the "explicit"
+// struct tag only takes effect on Unmarshal -- so building a fixture that
+// round-trips through parseSignedData requires doing this wrapping by hand.
+func explicitWrap(tag int, der []byte) []byte {
+	header := append([]byte{0xA0 | byte(tag)}, berLength(len(der))...)
+	return append(header, der...)
+}
+
+func berLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for n > 0 {
+		b = append([]byte{byte(n)}, b...)
+		n >>= 8
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	der, err := asn1.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshaling fixture: %v", err)
+	}
+	return der
+}
+
+// buildTimestampToken assembles the DER bytes of a minimal, but
+// RFC 5652/RFC 3161 shaped, TimeStampToken: a ContentInfo of type
+// signedData wrapping a SignedData whose encapContentInfo carries a TSTInfo
+// as an OCTET STRING eContent -- the same shape real TSAs emit.
+func buildTimestampToken(t *testing.T, genTime time.Time) []byte {
+	t.Helper()
+	imprint := mustMarshal(t, []byte{0xAA, 0xBB, 0xCC, 0xDD})
+	serial := mustMarshal(t, 1)
+	info := tstInfo{
+		Version:        1,
+		Policy:         asn1.ObjectIdentifier{1, 2, 3, 4},
+		MessageImprint: asn1.RawValue{FullBytes: imprint},
+		SerialNumber:   asn1.RawValue{FullBytes: serial},
+		GenTime:        genTime,
+	}
+	infoDER := mustMarshal(t, info)
+	octetTLV := mustMarshal(t, infoDER)
+	eContent := explicitWrap(0, octetTLV)
+
+	tokenContentInfo := pkcs7ContentInfo{
+		ContentType: oidTestTSTInfo,
+		Content:     asn1.RawValue{FullBytes: eContent},
+	}
+	token := pkcs7SignedData{
+		Version:     3,
+		ContentInfo: tokenContentInfo,
+	}
+	tokenDER := mustMarshal(t, token)
+
+	wrapped := explicitWrap(0, tokenDER)
+	outer := pkcs7ContentInfo{
+		ContentType: oidTestSignedData,
+		Content:     asn1.RawValue{FullBytes: wrapped},
+	}
+	return mustMarshal(t, outer)
+}
+
+// TestSignedDataTimestamps confirms timestamps() correctly double-unwraps a
+// TimeStampToken's OCTET STRING eContent before parsing the TSTInfo, rather
+// than trying to parse the OCTET STRING TLV directly as a TSTInfo SEQUENCE.
+func TestSignedDataTimestamps(t *testing.T) {
+	want := time.Date(2024, 3, 15, 12, 30, 0, 0, time.UTC)
+	token := buildTimestampToken(t, want)
+
+	sd := &pkcs7SignedData{
+		SignerInfos: []pkcs7SignerInfo{{
+			UnauthenticatedAttributes: []pkcs7Attribute{{
+				Type:   oidRFC3161Timestamp,
+				Values: []asn1.RawValue{{FullBytes: token}},
+			}},
+		}},
+	}
+
+	got, err := sd.timestamps()
+	if err != nil {
+		t.Fatalf("timestamps(): %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("timestamps(): got %d entries, want 1", len(got))
+	}
+	if !got[0].Equal(want) {
+		t.Errorf("timestamps(): got %v, want %v", got[0], want)
+	}
+}
+
+// TestSignedDataTimestampsNone confirms an MSI with no timestamp attribute
+// reports no timestamps rather than erroring.
+func TestSignedDataTimestampsNone(t *testing.T) {
+	sd := &pkcs7SignedData{
+		SignerInfos: []pkcs7SignerInfo{{}},
+	}
+	got, err := sd.timestamps()
+	if err != nil {
+		t.Fatalf("timestamps(): %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("timestamps(): got %d entries, want 0", len(got))
+	}
+}
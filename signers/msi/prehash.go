@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) SAS Institute Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package msi
+
+// Wire format used by --prehash: instead of streaming the whole MSI to the
+// signing service, upload a tiny tagged blob holding the MSI digest that
+// SignMSIImprint signs and the MsiDigitalSignatureEx digest. This lets a
+// client hash a multi-gigabyte
+// installer locally and send only a few dozen bytes to a remote signer.
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var prehashMagic = [8]byte{'R', 'E', 'L', 'I', 'C', 'P', 'H', '1'}
+
+type prehashEnvelope struct {
+	Hash      crypto.Hash
+	MsiDigest []byte
+	ExDigest  []byte
+}
+
+func (e *prehashEnvelope) Marshal() []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(prehashMagic[:])
+	buf.WriteByte(byte(e.Hash))
+	writeLenPrefixed(buf, e.MsiDigest)
+	writeLenPrefixed(buf, e.ExDigest)
+	return buf.Bytes()
+}
+
+func writeLenPrefixed(buf *bytes.Buffer, b []byte) {
+	var n [2]byte
+	binary.BigEndian.PutUint16(n[:], uint16(len(b)))
+	buf.Write(n[:])
+	buf.Write(b)
+}
+
+func readLenPrefixed(r io.Reader) ([]byte, error) {
+	var n [2]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(n[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// isPrehash reports whether the first bytes read from the wire look like a
+// prehash envelope rather than the start of an MSI tar stream.
+func isPrehash(head []byte) bool {
+	return len(head) >= len(prehashMagic) && bytes.Equal(head[:len(prehashMagic)], prehashMagic[:])
+}
+
+// unmarshalPrehash decodes a prehashEnvelope previously written by Marshal.
+// r must be positioned at the start of the envelope: the magic bytes are
+// read and checked here, so a preceding Peek must not have consumed them.
+func unmarshalPrehash(r io.Reader) (*prehashEnvelope, error) {
+	var magic [8]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if magic != prehashMagic {
+		return nil, errors.New("msi: not a prehash envelope")
+	}
+	var hashByte [1]byte
+	if _, err := io.ReadFull(r, hashByte[:]); err != nil {
+		return nil, err
+	}
+	e := &prehashEnvelope{Hash: crypto.Hash(hashByte[0])}
+	var err error
+	if e.MsiDigest, err = readLenPrefixed(r); err != nil {
+		return nil, err
+	}
+	if e.ExDigest, err = readLenPrefixed(r); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// verifyImprintDigest confirms that the imprint signed by the returned
+// PKCS#7 blob matches the digest we sent for signing, so a malicious or
+// buggy signing service can't substitute a different payload for the one
+// the client actually hashed.
+func verifyImprintDigest(psd, want []byte) error {
+	sd, err := parseSignedData(psd)
+	if err != nil {
+		return err
+	}
+	got, err := sd.imprint()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return errors.New("msi: signed digest does not match the prehashed digest that was sent for signing")
+	}
+	return nil
+}
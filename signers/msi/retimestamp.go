@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) SAS Institute Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package msi
+
+// --retimestamp-only rides the existing transform/sign/Apply pipeline to
+// attach a fresh RFC3161 timestamp to an MSI that's already signed, without
+// re-signing the Authenticode signature itself. This covers installers
+// that were signed offline, or whose existing TSA token is nearing the TSA
+// certificate's own expiry.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gerrit-pdt.unx.sas.com/tools/relic.git/lib/authenticode"
+	"gerrit-pdt.unx.sas.com/tools/relic.git/lib/comdoc"
+)
+
+var retimestampMagic = [8]byte{'R', 'E', 'L', 'I', 'C', 'R', 'T', '1'}
+
+// retimestampEnvelope carries an already-signed MSI's PKCS#7 blob through
+// GetReader/sign so it can be resubmitted to a TSA without rehashing the MSI.
+type retimestampEnvelope struct {
+	PSD []byte
+}
+
+func (e *retimestampEnvelope) Marshal() []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(retimestampMagic[:])
+	writeLenPrefixed(buf, e.PSD)
+	return buf.Bytes()
+}
+
+// isRetimestamp reports whether the first bytes read from the wire look
+// like a retimestampEnvelope rather than the start of an MSI tar stream.
+func isRetimestamp(head []byte) bool {
+	return len(head) >= len(retimestampMagic) && bytes.Equal(head[:len(retimestampMagic)], retimestampMagic[:])
+}
+
+// unmarshalRetimestamp decodes a retimestampEnvelope previously written by
+// Marshal. r must be positioned at the start of the envelope: the magic
+// bytes are read and checked here, so a preceding Peek must not have
+// consumed them.
+func unmarshalRetimestamp(r io.Reader) (*retimestampEnvelope, error) {
+	var magic [8]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if magic != retimestampMagic {
+		return nil, errors.New("msi: not a retimestamp envelope")
+	}
+	psd, err := readLenPrefixed(r)
+	if err != nil {
+		return nil, err
+	}
+	return &retimestampEnvelope{PSD: psd}, nil
+}
+
+// VerifyTimestamps reports the signing time of every RFC3161 timestamp
+// attached to an MSI's Authenticode signature -- the one from the original
+// signing plus any added later via --retimestamp-only -- so long-term
+// validity can be demonstrated even once an individual TSA's certificate
+// has expired.
+func VerifyTimestamps(f *os.File) ([]time.Time, error) {
+	cdf, err := comdoc.ReadFile(f)
+	if err != nil {
+		return nil, err
+	}
+	psd, _, err := authenticode.ExtractMSISignature(cdf)
+	if err != nil {
+		return nil, fmt.Errorf("msi: reading existing signature: %w", err)
+	}
+	sd, err := parseSignedData(psd)
+	if err != nil {
+		return nil, fmt.Errorf("msi: %w", err)
+	}
+	return sd.timestamps()
+}
@@ -19,8 +19,11 @@ package msi
 // Sign Microsoft Installer files
 
 import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 
 	"gerrit-pdt.unx.sas.com/tools/relic.git/lib/atomicfile"
@@ -44,6 +47,12 @@ var MsiSigner = &signers.Signer{
 
 func init() {
 	MsiSigner.Flags().Bool("no-extended-sig", false, "(MSI) Don't emit a MsiDigitalSignatureEx digest")
+	MsiSigner.Flags().Bool("prehash", false, "(MSI) Hash the MSI locally and upload only the digests, not the whole file")
+	MsiSigner.Flags().Bool("retimestamp-only", false, "(MSI) Attach a fresh RFC3161 timestamp to an already-signed MSI without re-signing it")
+	MsiSigner.Flags().String("attest", "", "(MSI) Path to a predicate JSON file; emit a signed in-toto attestation alongside the Authenticode signature")
+	MsiSigner.Flags().String("predicate-type", "", "(MSI) predicateType URI to use with --attest")
+	MsiSigner.Flags().String("signify-key", "", "(MSI) Path to a signify secret key; add a signify Ed25519 side-signature alongside the Authenticode signature")
+	MsiSigner.Flags().String("signify-pubkey", "", "(MSI) Path to a signify public key; verify the MSI's signify side-signature against it")
 	signers.Register(MsiSigner)
 }
 
@@ -51,6 +60,12 @@ type msiTransformer struct {
 	f     *os.File
 	cdf   *comdoc.ComDoc
 	exsig []byte
+
+	prehash         bool
+	retimestampOnly bool
+	hash            crypto.Hash
+	msiDigest       []byte
+	psd             []byte
 }
 
 func transform(f *os.File, opts signers.SignOpts) (signers.Transformer, error) {
@@ -58,19 +73,46 @@ func transform(f *os.File, opts signers.SignOpts) (signers.Transformer, error) {
 	if err != nil {
 		return nil, err
 	}
-	var exsig []byte
+	t := &msiTransformer{f: f, cdf: cdf, hash: opts.Hash}
+	if t.retimestampOnly, _ = opts.Flags.GetBool("retimestamp-only"); t.retimestampOnly {
+		t.psd, t.exsig, err = authenticode.ExtractMSISignature(cdf)
+		if err != nil {
+			return nil, fmt.Errorf("msi: reading existing signature: %w", err)
+		}
+		return t, nil
+	}
 	noExtended, _ := opts.Flags.GetBool("no-extended-sig")
 	if !noExtended {
-		exsig, err = authenticode.PrehashMSI(cdf, opts.Hash)
+		t.exsig, err = authenticode.PrehashMSI(cdf, opts.Hash)
 		if err != nil {
 			return nil, err
 		}
 	}
-	return &msiTransformer{f, cdf, exsig}, nil
+	if t.prehash, _ = opts.Flags.GetBool("prehash"); t.prehash {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(authenticode.MsiToTar(cdf, pw))
+		}()
+		t.msiDigest, err = authenticode.DigestMsiTar(pr, opts.Hash, !noExtended)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
 }
 
-// transform the MSI to a tar stream for upload
+// transform the MSI to a tar stream for upload, to a small prehash envelope
+// if --prehash was given, or to the existing PKCS#7 blob if --retimestamp-only
+// was given
 func (t *msiTransformer) GetReader() (io.Reader, int64, error) {
+	if t.retimestampOnly {
+		blob := (&retimestampEnvelope{PSD: t.psd}).Marshal()
+		return bytes.NewReader(blob), int64(len(blob)), nil
+	}
+	if t.prehash {
+		blob := (&prehashEnvelope{Hash: t.hash, MsiDigest: t.msiDigest, ExDigest: t.exsig}).Marshal()
+		return bytes.NewReader(blob), int64(len(blob)), nil
+	}
 	r, w := io.Pipe()
 	go func() {
 		w.CloseWithError(authenticode.MsiToTar(t.cdf, w))
@@ -78,12 +120,22 @@ func (t *msiTransformer) GetReader() (io.Reader, int64, error) {
 	return r, -1, nil
 }
 
-// apply a signed PKCS#7 blob to an already-open MSI document
+// apply a signed PKCS#7 blob, and any side artifacts alongside it, to an
+// already-open MSI document
 func (t *msiTransformer) Apply(dest, mimeType string, result io.Reader) error {
-	blob, err := ioutil.ReadAll(result)
+	blocks, err := readBlocks(result)
 	if err != nil {
 		return err
 	}
+	blob, ok := blocks[blockNamePKCS7]
+	if !ok {
+		return errMissingPKCS7
+	}
+	if t.prehash {
+		if err := verifyImprintDigest(blob, t.msiDigest); err != nil {
+			return fmt.Errorf("msi: %w", err)
+		}
+	}
 	// copy src to dest if needed, otherwise open in-place
 	f, err := atomicfile.WriteInPlace(t.f, dest)
 	if err != nil {
@@ -97,24 +149,88 @@ func (t *msiTransformer) Apply(dest, mimeType string, result io.Reader) error {
 	if err := authenticode.InsertMSISignature(cdf, blob, t.exsig); err != nil {
 		return err
 	}
+	if attestation, ok := blocks[blockNameAttestation]; ok {
+		if err := authenticode.InsertMSIAttestation(cdf, attestation); err != nil {
+			return err
+		}
+	}
+	if signify, ok := blocks[blockNameSignify]; ok {
+		if err := authenticode.InsertMSISignify(cdf, signify); err != nil {
+			return err
+		}
+	}
 	if err := cdf.Close(); err != nil {
 		return err
 	}
 	return f.Commit()
 }
 
-// sign a transformed tarball and return the PKCS#7 blob
+// sign a transformed tarball (or prehash envelope) and return the PKCS#7
+// blob, along with any side artifacts such as an in-toto attestation
 func sign(r io.Reader, cert *certloader.Certificate, opts signers.SignOpts) ([]byte, error) {
 	noExtended, _ := opts.Flags.GetBool("no-extended-sig")
-	sum, err := authenticode.DigestMsiTar(r, opts.Hash, !noExtended)
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(len(retimestampMagic)); isRetimestamp(head) {
+		env, err := unmarshalRetimestamp(br)
+		if err != nil {
+			return nil, err
+		}
+		newPsd, err := pkcs.Timestamp(env.PSD, cert, opts, true)
+		if err != nil {
+			return nil, fmt.Errorf("msi: requesting timestamp: %w", err)
+		}
+		return writeBlocks(map[string][]byte{blockNamePKCS7: newPsd}), nil
+	}
+	var sum []byte
+	if head, _ := br.Peek(len(prehashMagic)); isPrehash(head) {
+		env, err := unmarshalPrehash(br)
+		if err != nil {
+			return nil, err
+		}
+		if env.Hash != opts.Hash {
+			return nil, fmt.Errorf("msi: prehash envelope was built for %s, not %s", env.Hash, opts.Hash)
+		}
+		sum = env.MsiDigest
+	} else {
+		var err error
+		sum, err = authenticode.DigestMsiTar(br, opts.Hash, !noExtended)
+		if err != nil {
+			return nil, err
+		}
+	}
+	psd, err := authenticode.SignMSIImprint(sum, opts.Hash, cert.Signer(), cert.Chain())
 	if err != nil {
 		return nil, err
 	}
-	psd, err := authenticode.SignMSIImprint(sum, opts.Hash, cert.Signer(), cert.Chain())
+	signed, err := pkcs.Timestamp(psd, cert, opts, true)
+	if err != nil {
+		return nil, err
+	}
+	attestation, err := buildAttestation(opts.Path, sum, opts.Hash, cert, opts)
 	if err != nil {
 		return nil, err
 	}
-	return pkcs.Timestamp(psd, cert, opts, true)
+	signifyKey, _ := opts.Flags.GetString("signify-key")
+	signify, err := buildSignifyBlock(sum, signifyKey)
+	if err != nil {
+		return nil, err
+	}
+	return writeBlocks(map[string][]byte{
+		blockNamePKCS7:       signed,
+		blockNameAttestation: attestation,
+		blockNameSignify:     signify,
+	}), nil
+}
+
+// digestMSI computes the same digest over cdf that sign() binds its side
+// artifacts (the attestation, the signify signature) to, so verifying those
+// artifacts later doesn't require re-deriving the tar-and-hash pipeline.
+func digestMSI(cdf *comdoc.ComDoc, hash crypto.Hash, extended bool) ([]byte, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(authenticode.MsiToTar(cdf, pw))
+	}()
+	return authenticode.DigestMsiTar(pr, hash, extended)
 }
 
 func verify(f *os.File, opts signers.VerifyOpts) ([]*signers.Signature, error) {
@@ -122,8 +238,25 @@ func verify(f *os.File, opts signers.VerifyOpts) ([]*signers.Signature, error) {
 	if err != nil {
 		return nil, err
 	}
-	return []*signers.Signature{&signers.Signature{
+	if _, err := VerifyTimestamps(f); err != nil {
+		return nil, fmt.Errorf("msi: verifying timestamps: %w", err)
+	}
+	if _, err := VerifyAttestation(f, sig.HashFunc); err != nil {
+		return nil, fmt.Errorf("msi: verifying attestation: %w", err)
+	}
+	sigs := []*signers.Signature{{
 		Hash:          sig.HashFunc,
 		X509Signature: &sig.TimestampedSignature,
-	}}, nil
-}
\ No newline at end of file
+	}}
+	if signifyPubkey, _ := opts.Flags.GetString("signify-pubkey"); signifyPubkey != "" {
+		pub, err := loadSignifyPublicKey(signifyPubkey)
+		if err != nil {
+			return nil, fmt.Errorf("msi: loading --signify-pubkey: %w", err)
+		}
+		if err := VerifySignify(f, sig.HashFunc, pub); err != nil {
+			return nil, fmt.Errorf("msi: verifying signify signature: %w", err)
+		}
+		sigs = append(sigs, &signers.Signature{Hash: sig.HashFunc})
+	}
+	return sigs, nil
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) SAS Institute Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package msi
+
+// --signify-key pairs the heavyweight PKCS#7/X.509 Authenticode signature
+// with a small signify-style Ed25519 signature over the same digest, so
+// tooling that only trusts one pinned Ed25519 public key can verify an MSI
+// in microseconds without pulling in the PKCS#7/X.509/TSA stack.
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gerrit-pdt.unx.sas.com/tools/relic.git/lib/authenticode"
+	"gerrit-pdt.unx.sas.com/tools/relic.git/lib/comdoc"
+)
+
+const (
+	blockNameSignify = "signify"
+	signifyAlgorithm = "Ed"
+	signifyKeyNumLen = 8
+)
+
+type signifySecretKey struct {
+	KeyNum [signifyKeyNumLen]byte
+	Key    ed25519.PrivateKey
+}
+
+// loadSignifySecretKey reads an unencrypted signify secret-key file: an
+// "untrusted comment:" line followed by base64("Ed" || keynum || privkey).
+func loadSignifySecretKey(path string) (*signifySecretKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.SplitN(strings.TrimSpace(string(data)), "\n", 2)
+	if len(lines) != 2 {
+		return nil, errors.New("signify: malformed secret key file")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil {
+		return nil, fmt.Errorf("signify: %w", err)
+	}
+	if len(raw) != 2+signifyKeyNumLen+ed25519.PrivateKeySize || string(raw[:2]) != signifyAlgorithm {
+		return nil, errors.New("signify: unrecognized secret key format")
+	}
+	sk := &signifySecretKey{Key: ed25519.PrivateKey(raw[2+signifyKeyNumLen:])}
+	copy(sk.KeyNum[:], raw[2:2+signifyKeyNumLen])
+	return sk, nil
+}
+
+// buildSignifyBlock signs digest with the signify secret key at keyPath, if
+// one was given, and returns the signify wire-format blob to store in the
+// \5SignifySignature stream. It returns nil if --signify-key wasn't set.
+func buildSignifyBlock(digest []byte, keyPath string) ([]byte, error) {
+	if keyPath == "" {
+		return nil, nil
+	}
+	sk, err := loadSignifySecretKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	sig := ed25519.Sign(sk.Key, digest)
+	return encodeSignify(sk.KeyNum, sig), nil
+}
+
+func encodeSignify(keyNum [signifyKeyNumLen]byte, sig []byte) []byte {
+	raw := make([]byte, 0, len(signifyAlgorithm)+signifyKeyNumLen+len(sig))
+	raw = append(raw, signifyAlgorithm...)
+	raw = append(raw, keyNum[:]...)
+	raw = append(raw, sig...)
+	var buf bytes.Buffer
+	buf.WriteString("untrusted comment: signify signature over the MSI's Authenticode digest\n")
+	buf.WriteString(base64.StdEncoding.EncodeToString(raw))
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func decodeSignify(blob []byte) (sig []byte, err error) {
+	lines := strings.SplitN(strings.TrimSpace(string(blob)), "\n", 2)
+	if len(lines) != 2 {
+		return nil, errors.New("signify: malformed signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != len(signifyAlgorithm)+signifyKeyNumLen+ed25519.SignatureSize || string(raw[:len(signifyAlgorithm)]) != signifyAlgorithm {
+		return nil, errors.New("signify: unrecognized signature format")
+	}
+	return raw[len(signifyAlgorithm)+signifyKeyNumLen:], nil
+}
+
+// VerifySignify validates the signify-format Ed25519 side-signature on an
+// MSI against a pinned public key. It doesn't touch the Authenticode
+// signature or its certificate chain at all.
+func VerifySignify(f *os.File, hash crypto.Hash, pub ed25519.PublicKey) error {
+	cdf, err := comdoc.ReadFile(f)
+	if err != nil {
+		return err
+	}
+	blob, err := authenticode.ExtractMSISignify(cdf)
+	if err != nil {
+		return err
+	}
+	if blob == nil {
+		return errors.New("signify: MSI has no signify signature")
+	}
+	sig, err := decodeSignify(blob)
+	if err != nil {
+		return err
+	}
+	// buildSignifyBlock binds the signature to whichever digest sign()
+	// computed, which depends on --no-extended-sig; try the default
+	// (extended) first, since that's what most MSIs were signed with.
+	for _, extended := range []bool{true, false} {
+		digest, err := digestMSI(cdf, hash, extended)
+		if err != nil {
+			return err
+		}
+		if ed25519.Verify(pub, digest, sig) {
+			return nil
+		}
+	}
+	return errors.New("signify: signature is invalid")
+}
+
+// loadSignifyPublicKey reads a signify public-key file: an "untrusted
+// comment:" line followed by base64("Ed" || keynum || pubkey).
+func loadSignifyPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.SplitN(strings.TrimSpace(string(data)), "\n", 2)
+	if len(lines) != 2 {
+		return nil, errors.New("signify: malformed public key file")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil {
+		return nil, fmt.Errorf("signify: %w", err)
+	}
+	if len(raw) != 2+signifyKeyNumLen+ed25519.PublicKeySize || string(raw[:2]) != signifyAlgorithm {
+		return nil, errors.New("signify: unrecognized public key format")
+	}
+	return ed25519.PublicKey(raw[2+signifyKeyNumLen:]), nil
+}
+
+// GenerateSignifyKeygen creates a new Ed25519 signify key pair, returning
+// the secret-key and public-key file contents respectively. It backs the
+// `relic signify-keygen` CLI helper.
+func GenerateSignifyKeygen() (secretKey, publicKey []byte, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	var keyNum [signifyKeyNumLen]byte
+	if _, err := rand.Read(keyNum[:]); err != nil {
+		return nil, nil, err
+	}
+	secRaw := append(append([]byte(signifyAlgorithm), keyNum[:]...), priv...)
+	pubRaw := append(append([]byte(signifyAlgorithm), keyNum[:]...), pub...)
+	return encodeSignifyKey("signify secret key", secRaw), encodeSignifyKey("signify public key", pubRaw), nil
+}
+
+func encodeSignifyKey(comment string, raw []byte) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "untrusted comment: %s\n", comment)
+	buf.WriteString(base64.StdEncoding.EncodeToString(raw))
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
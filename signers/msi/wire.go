@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) SAS Institute Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package msi
+
+// sign() only gets to return a single byte stream to Apply, but signing can
+// now produce more than one artifact to write back into the compound
+// document -- the PKCS#7 itself, plus an optional attestation or side
+// signature. Multiplex them into one stream of named blocks: one byte of
+// name length, the name, four bytes of big-endian block length, then the
+// block itself, repeated to EOF.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const blockNamePKCS7 = "pkcs7"
+
+var errMissingPKCS7 = errors.New("msi: sign result is missing the PKCS#7 block")
+
+func writeBlocks(blocks map[string][]byte) []byte {
+	buf := new(bytes.Buffer)
+	for name, data := range blocks {
+		if len(data) == 0 {
+			continue
+		}
+		buf.WriteByte(byte(len(name)))
+		buf.WriteString(name)
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+		buf.Write(n[:])
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
+func readBlocks(r io.Reader) (map[string][]byte, error) {
+	blocks := make(map[string][]byte)
+	for {
+		var nameLen [1]byte
+		if _, err := io.ReadFull(r, nameLen[:]); err != nil {
+			if err == io.EOF {
+				return blocks, nil
+			}
+			return nil, err
+		}
+		name := make([]byte, nameLen[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, err
+		}
+		var n [4]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return nil, err
+		}
+		data := make([]byte, binary.BigEndian.Uint32(n[:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		blocks[string(name)] = data
+	}
+}